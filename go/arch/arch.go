@@ -0,0 +1,42 @@
+// Package arch resolves a loaded binary's reported arch/os pair into the
+// models.Arch/models.OS combination Usercorn.Run needs to map and run it.
+package arch
+
+import (
+	"fmt"
+
+	"../models"
+)
+
+// arches and oses are filled in by each arch/OS file's own init(), so
+// adding a new platform -- like Darwin -- means registering it alongside
+// its implementation instead of adding a case here.
+var (
+	arches = map[string]*models.Arch{}
+	oses   = map[string]func(*models.Arch) *models.OS{}
+)
+
+// RegisterArch makes a available to GetArch under name.
+func RegisterArch(name string, a *models.Arch) {
+	arches[name] = a
+}
+
+// RegisterOS makes newOS available to GetArch under name.
+func RegisterOS(name string, newOS func(*models.Arch) *models.OS) {
+	oses[name] = newOS
+}
+
+// GetArch resolves archName/osName, as reported by a models.Loader's
+// Arch()/OS(), into the models.Arch and models.OS pair mapBinary and
+// setupStack need.
+func GetArch(archName, osName string) (*models.Arch, *models.OS, error) {
+	a, ok := arches[archName]
+	if !ok {
+		return nil, nil, fmt.Errorf("arch: unsupported arch %q", archName)
+	}
+	newOS, ok := oses[osName]
+	if !ok {
+		return nil, nil, fmt.Errorf("arch: unsupported os %q", osName)
+	}
+	return a, newOS(a), nil
+}
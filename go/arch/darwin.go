@@ -0,0 +1,135 @@
+package arch
+
+import (
+	"fmt"
+	"os"
+
+	"../models"
+)
+
+// darwin syscall class bits, packed into the top byte of the syscall
+// number the same way xnu's unix_syscall/mach_call dispatch does.
+const (
+	darwinClassMask  = 0xff000000
+	darwinClassShift = 24
+
+	darwinClassMach    = 1
+	darwinClassMachdep = 3
+)
+
+func init() {
+	RegisterOS("darwin", darwinOS)
+}
+
+// darwinOS returns the Darwin personality for a: stack layout, syscall
+// dispatch and the handful of Mach traps simple binaries poke at on their
+// way into libSystem.
+func darwinOS(a *models.Arch) *models.OS {
+	return &models.OS{
+		Init:      darwinInit,
+		Interrupt: darwinInterrupt,
+	}
+}
+
+// darwinInit lays out the Darwin-style initial stack: argc, argv, envp and
+// an apple[] vector terminated by NULL, carrying at minimum
+// executable_path=, mirroring xnu's exec_to_user(). Darwin has no auxv at
+// all, so this builds argc/argv/envp/apple[] directly instead of going
+// through PosixInit, whose leading "end of auxv" marker would otherwise
+// wedge a stray NULL word between envp and apple[].
+func darwinInit(u models.Usercorn, args, env []string) error {
+	apple := []string{"executable_path=" + args[0]}
+	appleAddrs, err := u.PushStrings(apple...)
+	if err != nil {
+		return err
+	}
+	envAddrs, err := u.PushStrings(env...)
+	if err != nil {
+		return err
+	}
+	argAddrs, err := u.PushStrings(args...)
+	if err != nil {
+		return err
+	}
+	if err := u.PushAddrs(appleAddrs); err != nil {
+		return err
+	}
+	if err := u.PushAddrs(envAddrs); err != nil {
+		return err
+	}
+	if err := u.PushAddrs(argAddrs); err != nil {
+		return err
+	}
+	return u.Push(uint64(len(args)))
+}
+
+// darwinSyscalls maps xnu's BSD/Unix class syscall numbers (sys/syscall.h)
+// to their names. This is xnu's own numbering, unrelated to Linux's beyond
+// both being POSIX-shaped -- e.g. Darwin's syscall 1 is exit, not write --
+// so numbers are translated to names here before dispatch; only the
+// handful of syscalls a simple binary's early startup actually needs are
+// listed.
+var darwinSyscalls = map[int]string{
+	1:   "exit",
+	3:   "read",
+	4:   "write",
+	5:   "open",
+	6:   "close",
+	20:  "getpid",
+	73:  "munmap",
+	197: "mmap",
+}
+
+// darwinInterrupt dispatches a syscall/SVC trap by splitting the syscall
+// number into its class (top byte) and per-class number, xnu's trap table
+// encoding. A negative number (how arm64 passes Mach traps in x16, with no
+// class bits) is a Mach trap too. Mach and Machdep traps are handled here
+// since their numbering is disjoint from BSD's; the Unix/BSD class is
+// looked up in darwinSyscalls and dispatched through the common Syscall()
+// path.
+func darwinInterrupt(u models.Usercorn, intno uint32) {
+	num, err := u.RegRead(u.Arch().SC)
+	if err != nil {
+		return
+	}
+	if int64(num) < 0 {
+		darwinMachTrap(u, int(int64(num)))
+		return
+	}
+	class := (num & darwinClassMask) >> darwinClassShift
+	raw := int(int32(uint32(num)<<8) >> 8)
+	switch class {
+	case darwinClassMach:
+		// x86_64's class-1 number is the positive trap index; darwinMachTrap
+		// uses xnu's negative Mach trap numbering.
+		darwinMachTrap(u, -raw)
+	case darwinClassMachdep:
+		fmt.Fprintf(os.Stderr, "unhandled machdep trap: %d\n", raw)
+	default:
+		name, ok := darwinSyscalls[raw]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unhandled bsd syscall: %d\n", raw)
+			return
+		}
+		u.Syscall(raw, name, u.SyscallArgs)
+	}
+}
+
+const (
+	machTrapTaskSelfTrap = -28
+	machTrapMachMsgTrap  = -31
+)
+
+// darwinMachTrap stubs just enough of the Mach trap surface (task_self_trap
+// and mach_msg_trap) for a simple binary's early libSystem bootstrap to get
+// a task port back instead of faulting.
+func darwinMachTrap(u models.Usercorn, num int) {
+	switch num {
+	case machTrapTaskSelfTrap:
+		u.RegWrite(u.Arch().Ret, 1)
+	case machTrapMachMsgTrap:
+		u.RegWrite(u.Arch().Ret, 0)
+	default:
+		fmt.Fprintf(os.Stderr, "unhandled mach trap: %d\n", num)
+	}
+}
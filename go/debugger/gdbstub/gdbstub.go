@@ -0,0 +1,440 @@
+// Package gdbstub exposes a running Usercorn instance over the GDB Remote
+// Serial Protocol on a TCP socket, so `gdb -ex "target remote :1234"` can
+// attach to an emulated process the same way it attaches to a real one.
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Target is the subset of Usercorn the stub needs. It's satisfied by
+// *main.Usercorn, partly through its embedded *Unicorn and partly through
+// methods Usercorn defines for the stub (Step, HookAddAddr, TargetXML, ...).
+type Target interface {
+	RegRead(reg int) (uint64, error)
+	RegWrite(reg int, val uint64) error
+	MemRead(addr, size uint64) ([]byte, error)
+	MemWrite(addr uint64, data []byte) error
+	HookAddAddr(addr uint64, cb func()) (disable func(), err error)
+	HookAddRange(begin, end uint64, cb func()) (disable func(), err error)
+	Start(begin, until uint64) error
+	Step(pc uint64) (uint64, error)
+	Stop() error
+	PC() (uint64, error)
+	Symbolicate(addr uint64) (string, error)
+	SymbolAddr(name string) (uint64, bool)
+	Base() uint64
+	InterpBase() uint64
+	TargetXML() string
+	RegOrder() []int
+	RegSizes() []int
+}
+
+// Stub serializes GDB RSP packets against a single Target so Unicorn is
+// only ever entered from the goroutine running Serve.
+type Stub struct {
+	t        Target
+	ln       net.Listener
+	conn     net.Conn
+	r        *bufio.Reader
+	bps      map[uint64]func()
+	stopAddr uint64
+	stopped  bool
+}
+
+// New returns a stub wrapping t. Call Listen, then Serve.
+func New(t Target) *Stub {
+	return &Stub{t: t, bps: make(map[uint64]func())}
+}
+
+// Listen opens addr (e.g. ":1234") and blocks until GDB connects.
+func (s *Stub) Listen(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.ln = ln
+	conn, err := ln.Accept()
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.r = bufio.NewReader(conn)
+	return nil
+}
+
+// Serve runs the packet loop starting execution at pc. It blocks until the
+// connection closes or the target exits.
+func (s *Stub) Serve(pc uint64) error {
+	defer s.conn.Close()
+	for {
+		pkt, err := s.readPacket()
+		if err != nil {
+			return err
+		}
+		if pkt == "" {
+			continue
+		}
+		cont, runPC, err := s.dispatch(pkt, pc)
+		if err != nil {
+			return err
+		}
+		if cont {
+			pc = runPC
+		}
+	}
+}
+
+func (s *Stub) dispatch(pkt string, pc uint64) (ranContinue bool, newPC uint64, err error) {
+	switch pkt[0] {
+	case 'g':
+		return false, pc, s.readRegs()
+	case 'G':
+		return false, pc, s.writeRegs(pkt[1:])
+	case 'p':
+		return false, pc, s.readReg(pkt[1:])
+	case 'P':
+		return false, pc, s.writeReg(pkt[1:])
+	case 'm':
+		return false, pc, s.readMem(pkt[1:])
+	case 'M':
+		return false, pc, s.writeMem(pkt[1:])
+	case 'Z':
+		return false, pc, s.setBreak(pkt[1:])
+	case 'z':
+		return false, pc, s.clearBreak(pkt[1:])
+	case 'q':
+		return false, pc, s.query(pkt[1:])
+	case 'c':
+		return s.resume(pc, false)
+	case 's':
+		return s.resume(pc, true)
+	case '?':
+		return false, pc, s.send("S05")
+	default:
+		return false, pc, s.send("")
+	}
+}
+
+// resume drives execution by re-entering Unicorn.Start from pc, either for
+// a single instruction (via Step) or until a breakpoint or error stops it.
+func (s *Stub) resume(pc uint64, step bool) (bool, uint64, error) {
+	var err error
+	stopPC := pc
+	if step {
+		stopPC, err = s.t.Step(pc)
+	} else {
+		err = s.t.Start(pc, 0xffffffffffffffff)
+		if p, regErr := s.t.PC(); regErr == nil {
+			stopPC = p
+		}
+	}
+	if err != nil {
+		s.send(fmt.Sprintf("W%02x", 0))
+		return false, stopPC, err
+	}
+	return true, stopPC, s.send("S05")
+}
+
+// regSize returns the byte width RegSizes declares for the n'th register
+// in RegOrder, falling back to 8 if the size list is short or missing.
+func (s *Stub) regSize(n int) int {
+	sizes := s.t.RegSizes()
+	if n < len(sizes) {
+		return sizes[n]
+	}
+	return 8
+}
+
+func (s *Stub) readRegs() error {
+	var sb strings.Builder
+	for i, reg := range s.t.RegOrder() {
+		val, err := s.t.RegRead(reg)
+		if err != nil {
+			val = 0
+		}
+		sb.WriteString(toLEHex(val, s.regSize(i)))
+	}
+	return s.send(sb.String())
+}
+
+func (s *Stub) writeRegs(data string) error {
+	order := s.t.RegOrder()
+	pos := 0
+	for i, reg := range order {
+		n := s.regSize(i) * 2
+		if pos+n > len(data) {
+			break
+		}
+		val := fromLEHex(data[pos : pos+n])
+		s.t.RegWrite(reg, val)
+		pos += n
+	}
+	return s.send("OK")
+}
+
+func (s *Stub) readReg(arg string) error {
+	n, err := strconv.ParseInt(arg, 16, 64)
+	if err != nil {
+		return s.send("E01")
+	}
+	order := s.t.RegOrder()
+	if int(n) >= len(order) {
+		return s.send("E01")
+	}
+	val, err := s.t.RegRead(order[n])
+	if err != nil {
+		return s.send("E01")
+	}
+	return s.send(toLEHex(val, s.regSize(int(n))))
+}
+
+func (s *Stub) writeReg(arg string) error {
+	parts := strings.SplitN(arg, "=", 2)
+	if len(parts) != 2 {
+		return s.send("E01")
+	}
+	n, err := strconv.ParseInt(parts[0], 16, 64)
+	if err != nil {
+		return s.send("E01")
+	}
+	order := s.t.RegOrder()
+	if int(n) >= len(order) {
+		return s.send("E01")
+	}
+	s.t.RegWrite(order[n], fromLEHex(parts[1]))
+	return s.send("OK")
+}
+
+func (s *Stub) readMem(arg string) error {
+	addr, size, ok := splitAddrLen(arg, ",")
+	if !ok {
+		return s.send("E01")
+	}
+	data, err := s.t.MemRead(addr, size)
+	if err != nil {
+		return s.send("E01")
+	}
+	return s.send(fmt.Sprintf("%x", data))
+}
+
+func (s *Stub) writeMem(arg string) error {
+	head, hexData, ok := cut(arg, ":")
+	if !ok {
+		return s.send("E01")
+	}
+	addr, _, ok := splitAddrLen(head, ",")
+	if !ok {
+		return s.send("E01")
+	}
+	data := make([]byte, len(hexData)/2)
+	for i := range data {
+		v, _ := strconv.ParseUint(hexData[i*2:i*2+2], 16, 8)
+		data[i] = byte(v)
+	}
+	if err := s.t.MemWrite(addr, data); err != nil {
+		return s.send("E01")
+	}
+	return s.send("OK")
+}
+
+// setBreak handles Z0 (software breakpoint, installed via a HOOK_CODE
+// address filter that stops the target) and Z1 (hardware breakpoint over a
+// byte range, same idea but via a ranged code hook).
+func (s *Stub) setBreak(arg string) error {
+	kind, addr, kindLen, ok := splitBreak(arg)
+	if !ok {
+		return s.send("E01")
+	}
+	var (
+		disable func()
+		err     error
+	)
+	switch kind {
+	case 0:
+		disable, err = s.t.HookAddAddr(addr, func() { s.t.Stop() })
+	case 1:
+		disable, err = s.t.HookAddRange(addr, addr+kindLen, func() { s.t.Stop() })
+	default:
+		return s.send("")
+	}
+	if err != nil {
+		return s.send("E01")
+	}
+	s.bps[addr] = disable
+	return s.send("OK")
+}
+
+// clearBreak disables the hook setBreak installed at addr, not just the
+// bookkeeping entry, so a cleared breakpoint actually stops firing.
+func (s *Stub) clearBreak(arg string) error {
+	_, addr, _, ok := splitBreak(arg)
+	if !ok {
+		return s.send("E01")
+	}
+	if disable, ok := s.bps[addr]; ok {
+		disable()
+		delete(s.bps, addr)
+	}
+	return s.send("OK")
+}
+
+func (s *Stub) query(arg string) error {
+	switch {
+	case strings.HasPrefix(arg, "Xfer:features:read:target.xml"):
+		return s.send(fmt.Sprintf("l%s", s.t.TargetXML()))
+	case strings.HasPrefix(arg, "Symbol:"):
+		return s.querySymbol(strings.TrimPrefix(arg, "Symbol:"))
+	case arg == "Offsets":
+		return s.queryOffsets()
+	case strings.HasPrefix(arg, "Supported"):
+		return s.send("PacketSize=4000;qXfer:features:read+")
+	default:
+		return s.send("")
+	}
+}
+
+// queryOffsets answers qOffsets with the base the target is actually
+// executing out of: once an interpreter has taken over (InterpBase set),
+// PC lives in the interpreter's mapping, not the main binary's, so GDB
+// needs the interpreter's base to symbolicate where it's stopped.
+func (s *Stub) queryOffsets() error {
+	base := s.t.Base()
+	if ib := s.t.InterpBase(); ib != 0 {
+		base = ib
+	}
+	return s.send(fmt.Sprintf("Text=%x;Data=%x;Bss=%x", base, base, base))
+}
+
+// querySymbol answers a qSymbol request. GDB sends "qSymbol:sym_value:
+// sym_name" (sym_value hex-encoded, empty if GDB doesn't know it) asking
+// the stub to resolve sym_name; the stub replies with its own
+// "qSymbol:sym_value:sym_name" if Symbolicate's address table can resolve
+// it, or "OK" once it has nothing further to ask for.
+func (s *Stub) querySymbol(rest string) error {
+	parts := strings.SplitN(rest, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return s.send("OK")
+	}
+	name, err := hexDecodeASCII(parts[1])
+	if err != nil || name == "" {
+		return s.send("OK")
+	}
+	if addr, ok := s.t.SymbolAddr(name); ok {
+		return s.send(fmt.Sprintf("qSymbol:%x:%s", addr, parts[1]))
+	}
+	return s.send("OK")
+}
+
+// --- wire format helpers ---
+
+func (s *Stub) readPacket() (string, error) {
+	for {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == '$' {
+			break
+		}
+		if b == 0x03 { // Ctrl-C: stop the inferior
+			s.t.Stop()
+		}
+	}
+	var sb strings.Builder
+	for {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == '#' {
+			break
+		}
+		sb.WriteByte(b)
+	}
+	// checksum, two hex bytes; ignored beyond framing
+	s.r.ReadByte()
+	s.r.ReadByte()
+	s.conn.Write([]byte{'+'})
+	return sb.String(), nil
+}
+
+func (s *Stub) send(data string) error {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	_, err := fmt.Fprintf(s.conn, "$%s#%02x", data, sum)
+	return err
+}
+
+func toLEHex(v uint64, size int) string {
+	b := make([]byte, size)
+	for i := 0; i < size; i++ {
+		b[i] = byte(v >> (8 * uint(i)))
+	}
+	return fmt.Sprintf("%x", b)
+}
+
+func fromLEHex(s string) uint64 {
+	var v uint64
+	for i := 0; i+2 <= len(s) && i < 16; i += 2 {
+		b, _ := strconv.ParseUint(s[i:i+2], 16, 8)
+		v |= uint64(b) << (4 * uint(i))
+	}
+	return v
+}
+
+// hexDecodeASCII decodes a hex-encoded ASCII string, the encoding qSymbol
+// uses for symbol names.
+func hexDecodeASCII(s string) (string, error) {
+	if len(s)%2 != 0 {
+		return "", fmt.Errorf("odd-length hex string %q", s)
+	}
+	b := make([]byte, len(s)/2)
+	for i := range b {
+		v, err := strconv.ParseUint(s[i*2:i*2+2], 16, 8)
+		if err != nil {
+			return "", err
+		}
+		b[i] = byte(v)
+	}
+	return string(b), nil
+}
+
+func splitAddrLen(s, sep string) (addr, length uint64, ok bool) {
+	a, b, found := cut(s, sep)
+	if !found {
+		return 0, 0, false
+	}
+	addr, err1 := strconv.ParseUint(a, 16, 64)
+	length, err2 := strconv.ParseUint(b, 16, 64)
+	return addr, length, err1 == nil && err2 == nil
+}
+
+// splitBreak parses "kind,addr,kindlen" as used by Z/z packets.
+func splitBreak(s string) (kind int, addr, kindLen uint64, ok bool) {
+	parts := strings.SplitN(s, ",", 3)
+	if len(parts) != 3 {
+		return 0, 0, 0, false
+	}
+	k, err1 := strconv.ParseInt(parts[0], 16, 8)
+	a, err2 := strconv.ParseUint(parts[1], 16, 64)
+	l, err3 := strconv.ParseUint(parts[2], 16, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, 0, 0, false
+	}
+	return int(k), a, l, true
+}
+
+func cut(s, sep string) (before, after string, found bool) {
+	i := strings.Index(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
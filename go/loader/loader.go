@@ -0,0 +1,54 @@
+// Package loader picks a models.Loader implementation for a binary on
+// disk, sniffing its magic bytes rather than trusting a file extension.
+package loader
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"../models"
+)
+
+// Binary types reported by a models.Loader's Type().
+const (
+	EXEC uint8 = iota
+	DYN
+)
+
+// format pairs a magic sniffer with the loader constructor it selects.
+// Each loader package registers its own format via Register instead of
+// LoadFile growing a case per file type.
+type format struct {
+	magic func([]byte) bool
+	new   func(path string) (models.Loader, error)
+}
+
+var formats []format
+
+// Register adds a file format to the ones LoadFile sniffs for. Called
+// from a loader package's own init(), e.g. loader/macho's.
+func Register(magic func([]byte) bool, newLoader func(path string) (models.Loader, error)) {
+	formats = append(formats, format{magic, newLoader})
+}
+
+// LoadFile sniffs path's leading bytes against every registered format and
+// hands off to the first one that matches.
+func LoadFile(path string) (models.Loader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(f, magic)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	for _, fm := range formats {
+		if fm.magic(magic) {
+			return fm.new(path)
+		}
+	}
+	return nil, fmt.Errorf("loader: %s: unrecognized file format", path)
+}
@@ -0,0 +1,182 @@
+// Package macho implements models.Loader for 32/64-bit Mach-O executables
+// and dylibs, so Darwin binaries can be mapped and run the same way ELF
+// binaries are.
+package macho
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/macho"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+
+	"../../loader"
+	"../../models"
+)
+
+// loadCmdMain is LC_MAIN, not exposed by debug/macho.
+const loadCmdMain macho.LoadCmd = 0x80000028
+
+func init() {
+	loader.Register(Magic, NewLoader)
+}
+
+// Magic reports whether b looks like a Mach-O (or fat Mach-O) file, for use
+// by loader.LoadFile's format sniffing.
+func Magic(b []byte) bool {
+	if len(b) < 4 {
+		return false
+	}
+	// Thin files are stored in their own byte order, so a little-endian
+	// file's magic reads byte-swapped here; fat headers are always
+	// big-endian.
+	switch binary.BigEndian.Uint32(b[:4]) {
+	case macho.Magic32, macho.Magic64, macho.MagicFat,
+		0xcefaedfe, 0xcffaedfe:
+		return true
+	}
+	return false
+}
+
+type MachOLoader struct {
+	file *macho.File
+}
+
+// NewLoader parses path as a (possibly fat) Mach-O file. Fat binaries
+// select their first architecture slice, matching how the rest of Usercorn
+// doesn't yet support multi-arch binaries.
+func NewLoader(path string) (models.Loader, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := macho.NewFile(bytes.NewReader(b))
+	if err != nil {
+		fat, ferr := macho.NewFatFile(bytes.NewReader(b))
+		if ferr != nil || len(fat.Arches) == 0 {
+			return nil, err
+		}
+		f = fat.Arches[0].File
+	}
+	return &MachOLoader{file: f}, nil
+}
+
+func (m *MachOLoader) Arch() string {
+	switch m.file.Cpu {
+	case macho.CpuAmd64:
+		return "x86_64"
+	case macho.Cpu386:
+		return "x86"
+	case macho.CpuArm64:
+		return "arm64"
+	case macho.CpuArm:
+		return "arm"
+	}
+	return ""
+}
+
+func (m *MachOLoader) OS() string {
+	return "darwin"
+}
+
+func (m *MachOLoader) Type() uint8 {
+	switch m.file.Type {
+	case macho.TypeExec:
+		// Nearly all real macOS executables are built MH_PIE; those need a
+		// load bias the same way ET_DYN ELFs do, so report them as DYN too.
+		if m.file.Flags&macho.FlagPIE != 0 {
+			return loader.DYN
+		}
+		return loader.EXEC
+	case macho.TypeDylib, macho.TypeBundle:
+		return loader.DYN
+	}
+	return loader.EXEC
+}
+
+func (m *MachOLoader) textBase() uint64 {
+	for _, l := range m.file.Loads {
+		if seg, ok := l.(*macho.Segment); ok && seg.Name == "__TEXT" {
+			return seg.Addr
+		}
+	}
+	return 0
+}
+
+// Entry returns the PC the kernel jumps to: LC_MAIN's entryoff (relative to
+// __TEXT) if present, else 0 for the rarer LC_UNIXTHREAD-only binaries
+// (their register state isn't decoded by debug/macho).
+func (m *MachOLoader) Entry() uint64 {
+	for _, l := range m.file.Loads {
+		raw := l.Raw()
+		if len(raw) < 16 {
+			continue
+		}
+		if macho.LoadCmd(m.file.ByteOrder.Uint32(raw[0:4])) == loadCmdMain {
+			return m.textBase() + m.file.ByteOrder.Uint64(raw[8:16])
+		}
+	}
+	return 0
+}
+
+func (m *MachOLoader) Interp() string {
+	for _, l := range m.file.Loads {
+		if d, ok := l.(*macho.Dylinker); ok {
+			return d.Name
+		}
+	}
+	return ""
+}
+
+func (m *MachOLoader) Segments() ([]models.Segment, error) {
+	var segs []models.Segment
+	for _, l := range m.file.Loads {
+		seg, ok := l.(*macho.Segment)
+		if !ok || seg.Name == "__PAGEZERO" {
+			continue
+		}
+		data, err := ioutil.ReadAll(seg.Open())
+		if err != nil {
+			return nil, err
+		}
+		if seg.Memsz > uint64(len(data)) {
+			data = append(data, make([]byte, seg.Memsz-uint64(len(data)))...)
+		}
+		segs = append(segs, models.Segment{Addr: seg.Addr, Size: seg.Memsz, Data: data})
+	}
+	if len(segs) == 0 {
+		return nil, errors.New("macho: no loadable segments")
+	}
+	return segs, nil
+}
+
+func (m *MachOLoader) Symbols() ([]models.Symbol, error) {
+	var syms []models.Symbol
+	if m.file.Symtab == nil {
+		return syms, nil
+	}
+	for _, s := range m.file.Symtab.Syms {
+		syms = append(syms, models.Symbol{Start: s.Value, Name: s.Name})
+	}
+	return syms, nil
+}
+
+func (m *MachOLoader) DataSegment() (uint64, uint64) {
+	for _, l := range m.file.Loads {
+		if seg, ok := l.(*macho.Segment); ok && seg.Name == "__DATA" {
+			return seg.Addr, seg.Addr + seg.Memsz
+		}
+	}
+	return 0, 0
+}
+
+func (m *MachOLoader) ByteOrder() binary.ByteOrder {
+	return m.file.ByteOrder
+}
+
+// DWARF implements models.DwarfLoader so DWARF-backed symbolication works
+// for Mach-O binaries too.
+func (m *MachOLoader) DWARF() (*dwarf.Data, error) {
+	return m.file.DWARF()
+}
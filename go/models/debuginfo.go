@@ -0,0 +1,244 @@
+package models
+
+import (
+	"debug/dwarf"
+	"sort"
+)
+
+// DwarfLoader is implemented by loaders that can expose the raw DWARF data
+// of a binary so DebugInfo can parse .debug_info/.debug_line/.debug_abbrev
+// out of it.
+type DwarfLoader interface {
+	DWARF() (*dwarf.Data, error)
+}
+
+// InlinedFrame represents one level of a DW_TAG_inlined_subroutine chain
+// covering a PC. Frames are ordered innermost first.
+type InlinedFrame struct {
+	Func string
+	File string
+	Line int
+}
+
+type lineRow struct {
+	PC     uint64
+	File   string
+	Line   int
+	IsStmt bool
+}
+
+type funcRange struct {
+	Low, High uint64
+	Name      string
+}
+
+type inlineRange struct {
+	Low, High uint64
+	Name      string
+	File      string // DW_AT_call_file, resolved against the CU's line table file list
+	Line      int
+	Depth     int
+}
+
+// DebugInfo holds the DWARF line and subprogram information for a single
+// loaded binary, flattened across compile units and sorted by PC so
+// LookupPC can binary-search. Addresses are unbiased (as they appear in the
+// binary); callers are expected to subtract the load base before calling
+// LookupPC and add it back when presenting results.
+type DebugInfo struct {
+	lines   []lineRow
+	funcs   []funcRange
+	inlines []inlineRange
+}
+
+// NewDebugInfo walks every compile unit in data, building a line matrix and
+// function/inlined-subroutine index.
+func NewDebugInfo(data *dwarf.Data) (*DebugInfo, error) {
+	di := &DebugInfo{}
+	r := data.Reader()
+	for {
+		cu, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if cu == nil {
+			break
+		}
+		if cu.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+		files, err := di.addLines(data, cu)
+		if err != nil {
+			return nil, err
+		}
+		if err := di.addFuncs(r, files); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(di.lines, func(i, j int) bool { return di.lines[i].PC < di.lines[j].PC })
+	sort.Slice(di.funcs, func(i, j int) bool { return di.funcs[i].Low < di.funcs[j].Low })
+	sort.Slice(di.inlines, func(i, j int) bool { return di.inlines[i].Low < di.inlines[j].Low })
+	return di, nil
+}
+
+// addLines reads cu's line table into di.lines, returning its file list (as
+// of the last entry read, per LineReader.Files' documented behavior) so
+// addFuncs can resolve DW_AT_call_file against it.
+func (di *DebugInfo) addLines(data *dwarf.Data, cu *dwarf.Entry) ([]*dwarf.LineFile, error) {
+	lr, err := data.LineReader(cu)
+	if err != nil || lr == nil {
+		return nil, err
+	}
+	var entry dwarf.LineEntry
+	for {
+		if err := lr.Next(&entry); err != nil {
+			break
+		}
+		di.lines = append(di.lines, lineRow{
+			PC:     entry.Address,
+			File:   entry.File.Name,
+			Line:   entry.Line,
+			IsStmt: entry.IsStmt,
+		})
+	}
+	return lr.Files(), nil
+}
+
+// addFuncs walks the compile unit's children (the reader is already
+// positioned just past the CU entry), recording DW_TAG_subprogram and
+// DW_TAG_inlined_subroutine ranges. depth tracks inlining nesting so
+// LookupPC can later return the covering frames innermost first. files is
+// cu's line-table file list, for resolving DW_AT_call_file.
+func (di *DebugInfo) addFuncs(r *dwarf.Reader, files []*dwarf.LineFile) error {
+	depth := 0
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return nil
+		}
+		if entry.Tag == 0 {
+			if depth == 0 {
+				return nil
+			}
+			depth--
+			continue
+		}
+		switch entry.Tag {
+		case dwarf.TagSubprogram:
+			if low, high, ok := entryRange(entry); ok {
+				name, _ := entry.Val(dwarf.AttrName).(string)
+				di.funcs = append(di.funcs, funcRange{low, high, name})
+			}
+		case dwarf.TagInlinedSubroutine:
+			if low, high, ok := entryRange(entry); ok {
+				name, _ := entry.Val(dwarf.AttrName).(string)
+				line, _ := entry.Val(dwarf.AttrCallLine).(int64)
+				file := callFile(files, entry.Val(dwarf.AttrCallFile))
+				di.inlines = append(di.inlines, inlineRange{low, high, name, file, int(line), depth})
+			}
+		}
+		if entry.Children {
+			depth++
+		}
+	}
+}
+
+// callFile resolves a DW_AT_call_file attribute value (a file-table index)
+// against cu's line-table file list, returning "" if it's missing, out of
+// range, or not the int64 dwarf.Val normally returns.
+func callFile(files []*dwarf.LineFile, v interface{}) string {
+	idx, ok := v.(int64)
+	if !ok || idx < 0 || int(idx) >= len(files) || files[idx] == nil {
+		return ""
+	}
+	return files[idx].Name
+}
+
+func entryRange(entry *dwarf.Entry) (low, high uint64, ok bool) {
+	lowVal := entry.Val(dwarf.AttrLowpc)
+	highVal := entry.Val(dwarf.AttrHighpc)
+	if lowVal == nil || highVal == nil {
+		return 0, 0, false
+	}
+	low, ok = lowVal.(uint64)
+	if !ok {
+		return 0, 0, false
+	}
+	switch h := highVal.(type) {
+	case uint64:
+		high = h
+	case int64:
+		high = low + uint64(h)
+	default:
+		return 0, 0, false
+	}
+	return low, high, true
+}
+
+// LookupPC resolves an unbiased pc to its enclosing function, source
+// location and offset into that function, plus any DW_TAG_inlined_subroutine
+// frames covering it (innermost first).
+func (di *DebugInfo) LookupPC(pc uint64) (fn, file string, line int, off uint64, inlined []InlinedFrame) {
+	if f, ok := di.findFunc(pc); ok {
+		fn = f.Name
+		off = pc - f.Low
+	}
+	if l, ok := di.findLine(pc); ok {
+		file = l.File
+		line = l.Line
+	}
+	var matches []inlineRange
+	for _, ir := range di.inlines {
+		if pc >= ir.Low && pc < ir.High {
+			matches = append(matches, ir)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Depth > matches[j].Depth })
+	for _, m := range matches {
+		// m.File is the inlined call site's own DW_AT_call_file, which can
+		// differ from the outer resolved file for cross-file inlining; fall
+		// back to it only if the call-file couldn't be resolved.
+		f := m.File
+		if f == "" {
+			f = file
+		}
+		inlined = append(inlined, InlinedFrame{Func: m.Name, File: f, Line: m.Line})
+	}
+	return
+}
+
+// FuncAddr returns the unbiased entry address of the named function, for
+// resolving a symbol name back to an address (e.g. gdbstub's qSymbol).
+func (di *DebugInfo) FuncAddr(name string) (uint64, bool) {
+	for _, f := range di.funcs {
+		if f.Name == name {
+			return f.Low, true
+		}
+	}
+	return 0, false
+}
+
+func (di *DebugInfo) findFunc(pc uint64) (funcRange, bool) {
+	i := sort.Search(len(di.funcs), func(i int) bool { return di.funcs[i].Low > pc }) - 1
+	if i >= 0 && pc < di.funcs[i].High {
+		return di.funcs[i], true
+	}
+	return funcRange{}, false
+}
+
+// findLine returns the line table row covering pc, walking backward from the
+// nearest preceding row to skip compiler-generated non-statement rows
+// (prologue/epilogue markers) that IsStmt exists to let callers avoid.
+func (di *DebugInfo) findLine(pc uint64) (lineRow, bool) {
+	i := sort.Search(len(di.lines), func(i int) bool { return di.lines[i].PC > pc }) - 1
+	for ; i >= 0; i-- {
+		if di.lines[i].IsStmt {
+			return di.lines[i], true
+		}
+	}
+	return lineRow{}, false
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GDBRegOrder and GDBTargetXML describe an arch's register file the way
+// GDB's Remote Serial Protocol expects it: a fixed order for g/G packets,
+// and a target.xml description of the same order for qXfer:features:read.
+// Both are driven off the same per-arch register spec list so the two
+// can never drift apart.
+
+// gdbReg names one register in an Arch's Regs map, plus the bitsize/type
+// target.xml wants for it.
+type gdbReg struct {
+	name    string
+	bitsize int
+	rtype   string
+}
+
+// x8664GDBRegs is GDB's org.gnu.gdb.i386.core register order for a bare
+// x86_64 target, the same order gdbserver reports.
+var x8664GDBRegs = []gdbReg{
+	{"RAX", 64, "int64"}, {"RBX", 64, "int64"}, {"RCX", 64, "int64"}, {"RDX", 64, "int64"},
+	{"RSI", 64, "int64"}, {"RDI", 64, "int64"}, {"RBP", 64, "data_ptr"}, {"RSP", 64, "data_ptr"},
+	{"R8", 64, "int64"}, {"R9", 64, "int64"}, {"R10", 64, "int64"}, {"R11", 64, "int64"},
+	{"R12", 64, "int64"}, {"R13", 64, "int64"}, {"R14", 64, "int64"}, {"R15", 64, "int64"},
+	{"RIP", 64, "code_ptr"}, {"EFLAGS", 32, "i386_eflags"},
+	{"CS", 32, "int32"}, {"SS", 32, "int32"}, {"DS", 32, "int32"},
+	{"ES", 32, "int32"}, {"FS", 32, "int32"}, {"GS", 32, "int32"},
+}
+
+// arm64GDBRegs is GDB's org.gnu.gdb.aarch64.core register order: x0-x30,
+// sp, pc, cpsr.
+var arm64GDBRegs = buildArm64GDBRegs()
+
+func buildArm64GDBRegs() []gdbReg {
+	regs := make([]gdbReg, 0, 34)
+	for i := 0; i <= 30; i++ {
+		regs = append(regs, gdbReg{fmt.Sprintf("X%d", i), 64, "int64"})
+	}
+	regs = append(regs, gdbReg{"SP", 64, "data_ptr"}, gdbReg{"PC", 64, "code_ptr"}, gdbReg{"CPSR", 32, "int32"})
+	return regs
+}
+
+// gdbRegsFor returns a's known GDB register spec list, or nil if a's arch
+// doesn't have one yet.
+func gdbRegsFor(a *Arch) []gdbReg {
+	switch a.Name {
+	case "x86_64":
+		return x8664GDBRegs
+	case "arm64":
+		return arm64GDBRegs
+	default:
+		return nil
+	}
+}
+
+// GDBRegOrder returns a's registers, as Arch register indices, in the same
+// order GDBTargetXML describes them. Architectures without a known
+// register list yet fall back to just pc/sp, so gdbstub degrades to
+// something still usable instead of failing outright.
+func GDBRegOrder(a *Arch) []int {
+	specs := gdbRegsFor(a)
+	if specs == nil {
+		return []int{a.PC, a.SP}
+	}
+	order := make([]int, 0, len(specs))
+	for _, s := range specs {
+		if r, ok := a.Regs[s.name]; ok {
+			order = append(order, r)
+		}
+	}
+	return order
+}
+
+// GDBRegSizes returns the byte width of each register GDBRegOrder returns,
+// in the same order, so gdbstub can encode/decode g/G packets at the width
+// target.xml actually advertises instead of assuming every register is 8
+// bytes wide.
+func GDBRegSizes(a *Arch) []int {
+	specs := gdbRegsFor(a)
+	if specs == nil {
+		return []int{8, 8}
+	}
+	sizes := make([]int, 0, len(specs))
+	for _, s := range specs {
+		if _, ok := a.Regs[s.name]; ok {
+			sizes = append(sizes, s.bitsize/8)
+		}
+	}
+	return sizes
+}
+
+// GDBTargetXML returns the target.xml GDB needs to interpret GDBRegOrder's
+// order.
+func GDBTargetXML(a *Arch) string {
+	specs := gdbRegsFor(a)
+	if specs == nil {
+		return `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target>
+  <reg name="pc" bitsize="64" type="code_ptr"/>
+  <reg name="sp" bitsize="64" type="data_ptr"/>
+</target>`
+	}
+	var b strings.Builder
+	b.WriteString("<?xml version=\"1.0\"?>\n<!DOCTYPE target SYSTEM \"gdb-target.dtd\">\n<target>\n")
+	for _, s := range specs {
+		if _, ok := a.Regs[s.name]; !ok {
+			continue
+		}
+		fmt.Fprintf(&b, "  <reg name=%q bitsize=\"%d\" type=%q/>\n", strings.ToLower(s.name), s.bitsize, s.rtype)
+	}
+	b.WriteString("</target>")
+	return b.String()
+}
@@ -0,0 +1,270 @@
+package models
+
+import "encoding/binary"
+
+// SymbolDataLoader is implemented by loaders that can hand back the raw
+// bytes backing a named linker symbol, not just a named section. The
+// FUNCDATA_InlTree table in Go's pclntab is addressed relative to the
+// "go:func.*" (pre-1.18: "go.func.*") linker symbol rather than any
+// section boundary, so GoInlineTree needs this instead of SectionLoader.
+type SymbolDataLoader interface {
+	SymbolData(name string) ([]byte, error)
+}
+
+const (
+	pcHeaderMagicGo120 = 0xfffffff1
+
+	goFuncDataInlTree  = 3
+	goPCDataInlTreeIdx = 2
+	goFuncFixedSize    = 44 // sizeof(_func) before the pcdata/funcdata arrays
+	goInlinedCallSize  = 16 // sizeof(runtime.inlinedCall)
+)
+
+// GoInlineTree resolves the inlined-call chain covering a PC by hand-
+// decoding pclntab's FUNCDATA_InlTree the way runtime.inlineUnwinder does,
+// for Go binaries whose DWARF has been stripped (debug/gosym has no
+// equivalent API). It only understands the go1.20+ pclntab layout (pcHeader
+// magic 0xfffffff1); older toolchains, go1.18/1.19 included, encode a
+// differently-shaped _func and inlinedCall and aren't handled here.
+type GoInlineTree struct {
+	pclntab   []byte
+	gofunc    []byte
+	textStart uint64
+	quantum   uint64
+	nfunc     uint64
+	funcnames uint64
+	pctab     uint64
+	funcOff   uint64 // header.pclnOffset: start of the functab index
+}
+
+// NewGoInlineTree parses pclntab's header and function index so PCInline
+// can resolve inline chains against gofunc, the raw bytes of the
+// "go:func.*" symbol. It returns nil (not an error) whenever inline
+// resolution isn't possible -- unrecognized header, truncated data -- since
+// all of those just mean "fall back to the outermost frame", not a hard
+// failure.
+func NewGoInlineTree(pclntab, gofunc []byte) *GoInlineTree {
+	if len(pclntab) < 72 || len(gofunc) == 0 {
+		return nil
+	}
+	if binary.LittleEndian.Uint32(pclntab[0:4]) != pcHeaderMagicGo120 {
+		return nil
+	}
+	t := &GoInlineTree{
+		pclntab:   pclntab,
+		gofunc:    gofunc,
+		quantum:   uint64(pclntab[6]),
+		nfunc:     binary.LittleEndian.Uint64(pclntab[8:16]),
+		textStart: binary.LittleEndian.Uint64(pclntab[24:32]),
+		funcnames: binary.LittleEndian.Uint64(pclntab[32:40]),
+		pctab:     binary.LittleEndian.Uint64(pclntab[56:64]),
+		funcOff:   binary.LittleEndian.Uint64(pclntab[64:72]),
+	}
+	if t.quantum == 0 {
+		return nil
+	}
+	return t
+}
+
+// PCInline returns the chain of inlined calls covering the biased addr
+// (innermost first), or nil if addr isn't in an inlined call -- including
+// when it isn't covered by any function at all.
+func (t *GoInlineTree) PCInline(addr, loadBias uint64) []InlinedFrame {
+	if addr < loadBias+t.textStart {
+		return nil
+	}
+	pcOff := addr - loadBias - t.textStart
+	entry, funcOff, ok := t.findFunc(pcOff)
+	if !ok {
+		return nil
+	}
+	npcdata, nfuncdata, fixedEnd, ok := t.funcCounts(funcOff)
+	if !ok {
+		return nil
+	}
+	inlTreeOff, ok := t.funcDataOffset(funcOff, fixedEnd, npcdata, nfuncdata, goFuncDataInlTree)
+	if !ok || int(inlTreeOff) >= len(t.gofunc) {
+		return nil
+	}
+	pcdataOff, ok := t.pcDataOffset(funcOff, fixedEnd, npcdata, goPCDataInlTreeIdx)
+	if !ok {
+		return nil
+	}
+	inlTree := t.gofunc[inlTreeOff:]
+	var frames []InlinedFrame
+	pc := t.textStart + pcOff   // == addr-loadBias, the unbiased query PC
+	for i := 0; i < 1000; i++ { // bound depth against a malformed/cyclic tree
+		idx := t.pcValue(pcdataOff, entry, pc)
+		if idx < 0 {
+			break
+		}
+		call, ok := t.inlinedCall(inlTree, uint32(idx))
+		if !ok {
+			break
+		}
+		name := t.cString(t.funcnames + uint64(call.nameOff))
+		if name == "" {
+			break
+		}
+		frames = append(frames, InlinedFrame{Func: name, Line: int(call.startLine)})
+		pc = entry + uint64(uint32(call.parentPc))
+	}
+	return frames
+}
+
+type goInlinedCall struct {
+	nameOff   int32
+	parentPc  int32
+	startLine int32
+}
+
+func (t *GoInlineTree) inlinedCall(base []byte, idx uint32) (goInlinedCall, bool) {
+	start := int(idx) * goInlinedCallSize
+	if start+goInlinedCallSize > len(base) {
+		return goInlinedCall{}, false
+	}
+	e := base[start : start+goInlinedCallSize]
+	return goInlinedCall{
+		nameOff:   int32(binary.LittleEndian.Uint32(e[4:8])),
+		parentPc:  int32(binary.LittleEndian.Uint32(e[8:12])),
+		startLine: int32(binary.LittleEndian.Uint32(e[12:16])),
+	}, true
+}
+
+// findFunc returns the entry PC (unbiased) and pclntab offset of the _func
+// record covering pcOff, an unbiased offset from textStart.
+func (t *GoInlineTree) findFunc(pcOff uint64) (entry, funcOff uint64, ok bool) {
+	ftab := t.sliceFrom(t.funcOff)
+	if ftab == nil || uint64(len(ftab)) < (t.nfunc+1)*8 {
+		return 0, 0, false
+	}
+	entryAt := func(i uint64) uint64 { return uint64(binary.LittleEndian.Uint32(ftab[i*8:])) }
+	lo, hi := uint64(0), t.nfunc
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if entryAt(mid) <= pcOff {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return 0, 0, false
+	}
+	idx := lo - 1
+	if pcOff >= entryAt(idx+1) {
+		return 0, 0, false
+	}
+	// The functab's funcoff column is relative to the start of the functab
+	// region itself (t.funcOff == header.pclnOffset), not to the start of
+	// pclntab as a whole.
+	return t.textStart + entryAt(idx), t.funcOff + uint64(binary.LittleEndian.Uint32(ftab[idx*8+4:])), true
+}
+
+// funcCounts reads npcdata/nfuncdata off the _func record at funcOff,
+// returning the byte offset where the trailing pcdata/funcdata arrays
+// start.
+func (t *GoInlineTree) funcCounts(funcOff uint64) (npcdata, nfuncdata uint32, fixedEnd uint64, ok bool) {
+	f := t.sliceFrom(funcOff)
+	if f == nil || uint64(len(f)) < goFuncFixedSize {
+		return 0, 0, 0, false
+	}
+	npcdata = binary.LittleEndian.Uint32(f[28:32])
+	nfuncdata = uint32(f[43])
+	return npcdata, nfuncdata, funcOff + goFuncFixedSize, true
+}
+
+func (t *GoInlineTree) pcDataOffset(funcOff, fixedEnd uint64, npcdata uint32, idx int) (uint64, bool) {
+	if uint32(idx) >= npcdata {
+		return 0, false
+	}
+	f := t.sliceFrom(fixedEnd + uint64(idx)*4)
+	if f == nil || len(f) < 4 {
+		return 0, false
+	}
+	return uint64(binary.LittleEndian.Uint32(f)), true
+}
+
+func (t *GoInlineTree) funcDataOffset(funcOff, fixedEnd uint64, npcdata, nfuncdata uint32, idx int) (uint32, bool) {
+	if uint32(idx) >= nfuncdata {
+		return 0, false
+	}
+	at := fixedEnd + uint64(npcdata)*4 + uint64(idx)*4
+	f := t.sliceFrom(at)
+	if f == nil || len(f) < 4 {
+		return 0, false
+	}
+	off := binary.LittleEndian.Uint32(f)
+	if off == ^uint32(0) {
+		return 0, false
+	}
+	return off, true
+}
+
+// pcValue replays the pctab varint run-length table starting at off,
+// mirroring runtime.pcvalue, to find the value in force at targetPC.
+func (t *GoInlineTree) pcValue(off, entry, targetPC uint64) int32 {
+	p := t.sliceFrom(t.pctab + off)
+	if p == nil {
+		return -1
+	}
+	pc := entry
+	val := int32(-1)
+	first := true
+	for {
+		uvdelta, n, ok := readUvarint(p)
+		if !ok {
+			break
+		}
+		if uvdelta == 0 && !first {
+			break
+		}
+		p = p[n:]
+		val += int32(-(uvdelta & 1) ^ (uvdelta >> 1))
+		pcdelta, n2, ok := readUvarint(p)
+		if !ok {
+			break
+		}
+		p = p[n2:]
+		pc += uint64(pcdelta) * t.quantum
+		first = false
+		if targetPC < pc {
+			return val
+		}
+	}
+	return -1
+}
+
+func readUvarint(p []byte) (val uint32, n int, ok bool) {
+	var shift uint
+	for n < len(p) {
+		b := p[n]
+		val |= uint32(b&0x7f) << shift
+		n++
+		if b&0x80 == 0 {
+			return val, n, true
+		}
+		shift += 7
+	}
+	return 0, 0, false
+}
+
+func (t *GoInlineTree) sliceFrom(off uint64) []byte {
+	if off >= uint64(len(t.pclntab)) {
+		return nil
+	}
+	return t.pclntab[off:]
+}
+
+func (t *GoInlineTree) cString(off uint64) string {
+	if off >= uint64(len(t.pclntab)) {
+		return ""
+	}
+	b := t.pclntab[off:]
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,48 @@
+package models
+
+import "debug/gosym"
+
+// SectionLoader is implemented by loaders that can hand back a named
+// section's load address and raw bytes, for features (like pclntab
+// parsing) that need to reach below the Segments()/Symbols() abstraction.
+type SectionLoader interface {
+	Section(name string) (addr uint64, data []byte, err error)
+}
+
+// NewGoTable builds a gosym.Table for a Go binary exposed through l, with
+// the line table's PCs biased by loadBias so lookups can use the addresses
+// Usercorn actually mapped the binary at. It returns a nil table (with a
+// nil error) if l has no pclntab, i.e. isn't a Go binary.
+func NewGoTable(l SectionLoader, loadBias uint64) (*gosym.Table, error) {
+	textAddr, _, err := l.Section(".text")
+	if err != nil {
+		return nil, nil
+	}
+	_, pclntab, err := l.Section(".gopclntab")
+	if err != nil || len(pclntab) == 0 {
+		return nil, nil
+	}
+	_, symtab, _ := l.Section(".gosymtab")
+	lt := gosym.NewLineTable(pclntab, loadBias+textAddr)
+	tab, err := gosym.NewTable(symtab, lt)
+	if err != nil {
+		return nil, err
+	}
+	return tab, nil
+}
+
+// genericGoSymbols are runtime entry points that show up as the "nearest"
+// ELF symbol for almost any PC early in a Go binary's startup, so they're a
+// worse answer than a real pclntab-resolved frame whenever one's available.
+var genericGoSymbols = map[string]bool{
+	"runtime.rt0_go":       true,
+	"_rt0_amd64":           true,
+	"_rt0_arm64":           true,
+	"runtime.buildVersion": true,
+}
+
+// IsGenericGoSymbol reports whether name is one of those unhelpful
+// catch-all symbols.
+func IsGenericGoSymbol(name string) bool {
+	return genericGoSymbols[name]
+}
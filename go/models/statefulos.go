@@ -0,0 +1,12 @@
+package models
+
+// StatefulOS is implemented by OS personalities that carry state worth
+// round-tripping through Usercorn.Snapshot/Restore: the open FD table, the
+// brk pointer, TLS base, and similar bookkeeping that isn't just mapped
+// memory or registers. Personalities that don't implement it are skipped
+// by Snapshot/Restore, the same way DwarfLoader-less loaders are skipped
+// by DWARF symbolication.
+type StatefulOS interface {
+	SaveState() ([]byte, error)
+	LoadState([]byte) error
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"./models"
+)
+
+// Snapshot/Restore serialize a full emulation checkpoint: every mapped
+// memory region, the register file, Usercorn's own load-time bookkeeping
+// and the OS personality's opaque state. The container is a magic, a
+// version and a flat list of typed, length-prefixed chunks so future
+// fields can be appended without breaking old snapshots.
+
+const (
+	snapMagic   = "USCS"
+	snapVersion = 1
+
+	chunkHeader = 1
+	chunkMem    = 2
+	chunkRegs   = 3
+	chunkOS     = 4
+)
+
+// Snapshot writes a complete checkpoint of u to w.
+func (u *Usercorn) Snapshot(w io.Writer) error {
+	if _, err := w.Write([]byte(snapMagic)); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(snapVersion)); err != nil {
+		return err
+	}
+	if err := u.writeChunk(w, chunkHeader, u.encodeHeader()); err != nil {
+		return err
+	}
+	mem, err := u.encodeMem()
+	if err != nil {
+		return err
+	}
+	if err := u.writeChunk(w, chunkMem, mem); err != nil {
+		return err
+	}
+	regs, err := u.encodeRegs()
+	if err != nil {
+		return err
+	}
+	if err := u.writeChunk(w, chunkRegs, regs); err != nil {
+		return err
+	}
+	if so, ok := u.OS.(models.StatefulOS); ok {
+		state, err := so.SaveState()
+		if err != nil {
+			return err
+		}
+		if err := u.writeChunk(w, chunkOS, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore tears down u's current Unicorn mappings and rebuilds the full
+// state recorded by Snapshot, restoring registers last so intermediate
+// mapping/OS-state writes can't stomp on them. Whatever is mapped when it
+// is called (e.g. what mapBinary laid out in NewUsercorn) is unmapped
+// first.
+func (u *Usercorn) Restore(r io.Reader) error {
+	magic := make([]byte, len(snapMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return err
+	}
+	if string(magic) != snapMagic {
+		return errors.New("Restore: bad magic, not a Usercorn snapshot")
+	}
+	var version uint32
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return err
+	}
+	if version != snapVersion {
+		return errors.New("Restore: unsupported snapshot version")
+	}
+	if err := u.teardownMappings(); err != nil {
+		return err
+	}
+	var regs, osState []byte
+	for {
+		kind, data, err := u.readChunk(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case chunkHeader:
+			if err := u.decodeHeader(data); err != nil {
+				return err
+			}
+		case chunkMem:
+			if err := u.decodeMem(data); err != nil {
+				return err
+			}
+		case chunkRegs:
+			regs = data
+		case chunkOS:
+			osState = data
+		}
+	}
+	if osState != nil {
+		if so, ok := u.OS.(models.StatefulOS); ok {
+			if err := so.LoadState(osState); err != nil {
+				return err
+			}
+		}
+	}
+	if err := u.addHooks(); err != nil {
+		return err
+	}
+	if regs != nil {
+		return u.decodeRegs(regs)
+	}
+	return nil
+}
+
+// teardownMappings unmaps everything currently mapped so Restore can
+// recreate the snapshot's regions from scratch without colliding with
+// whatever mapBinary/setupStack already laid out.
+func (u *Usercorn) teardownMappings() error {
+	regions, err := u.MemRegions()
+	if err != nil {
+		return err
+	}
+	for _, reg := range regions {
+		if err := u.MemUnmap(reg.Begin, reg.End-reg.Begin+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCheckpoint snapshots u to CheckpointFile, called periodically by the
+// "-checkpoint every=N" block hook.
+func (u *Usercorn) writeCheckpoint() error {
+	f, err := ioutil.TempFile("", "usercorn-checkpoint-")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := u.Snapshot(f); err != nil {
+		return err
+	}
+	if u.CheckpointFile != "" {
+		return os.Rename(f.Name(), u.CheckpointFile)
+	}
+	return nil
+}
+
+// runFromRestore implements "-restore file": it loads a prior Snapshot and
+// jumps straight into Unicorn.Start from the restored PC, skipping
+// setupStack/OS.Init. mapBinary is not skipped: NewUsercorn has already run
+// it by the time Run gets here, and Restore unmaps its mappings and maps the
+// snapshot's regions in their place.
+func (u *Usercorn) runFromRestore() error {
+	f, err := os.Open(u.RestoreFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := u.Restore(f); err != nil {
+		return err
+	}
+	// Registers (including PC) were restored last by Restore, so read the
+	// snapshot's actual stop PC back out rather than reusing the original
+	// entry point.
+	pc, err := u.RegRead(u.arch.PC)
+	if err != nil {
+		return err
+	}
+	return u.Unicorn.Start(pc, 0xffffffffffffffff)
+}
+
+func (u *Usercorn) writeChunk(w io.Writer, kind uint32, data []byte) error {
+	if err := binary.Write(w, binary.LittleEndian, kind); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(data))); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func (u *Usercorn) readChunk(r io.Reader) (kind uint32, data []byte, err error) {
+	if err = binary.Read(r, binary.LittleEndian, &kind); err != nil {
+		return 0, nil, err
+	}
+	var size uint64
+	if err = binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return 0, nil, err
+	}
+	data = make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	return kind, data, err
+}
+
+// encodeHeader captures everything mapBinary/setupStack compute that
+// Restore needs in order to skip them entirely: base addresses, the entry
+// points, the stack/data segment bounds, the load prefix and enough of the
+// loader's identity (path + content hash) to sanity-check a restore.
+func (u *Usercorn) encodeHeader() []byte {
+	var buf bytes.Buffer
+	writeU64 := func(v uint64) { binary.Write(&buf, binary.LittleEndian, v) }
+	writeStr := func(s string) {
+		writeU64(uint64(len(s)))
+		buf.WriteString(s)
+	}
+	writeU64(u.base)
+	writeU64(u.interpBase)
+	writeU64(u.entry)
+	writeU64(u.binEntry)
+	writeU64(u.StackBase)
+	writeU64(u.DataSegment.Start)
+	writeU64(u.DataSegment.End)
+	writeStr(u.LoadPrefix)
+	path, hash := u.loaderIdentity(u.loader)
+	writeStr(path)
+	writeStr(hash)
+	return buf.Bytes()
+}
+
+func (u *Usercorn) decodeHeader(data []byte) error {
+	r := bytes.NewReader(data)
+	readU64 := func() (uint64, error) {
+		var v uint64
+		err := binary.Read(r, binary.LittleEndian, &v)
+		return v, err
+	}
+	readStr := func() (string, error) {
+		n, err := readU64()
+		if err != nil {
+			return "", err
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	var err error
+	if u.base, err = readU64(); err != nil {
+		return err
+	}
+	if u.interpBase, err = readU64(); err != nil {
+		return err
+	}
+	if u.entry, err = readU64(); err != nil {
+		return err
+	}
+	if u.binEntry, err = readU64(); err != nil {
+		return err
+	}
+	if u.StackBase, err = readU64(); err != nil {
+		return err
+	}
+	if u.DataSegment.Start, err = readU64(); err != nil {
+		return err
+	}
+	if u.DataSegment.End, err = readU64(); err != nil {
+		return err
+	}
+	if u.LoadPrefix, err = readStr(); err != nil {
+		return err
+	}
+	// path/hash are recorded for post-mortem inspection; Restore doesn't
+	// re-run the loader since the memory chunk already has its output.
+	if _, err = readStr(); err != nil {
+		return err
+	}
+	if _, err = readStr(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (u *Usercorn) loaderIdentity(l models.Loader) (path, hash string) {
+	type pather interface{ Path() string }
+	if p, ok := l.(pather); ok {
+		path = p.Path()
+	}
+	if path == "" {
+		return "", ""
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return path, ""
+	}
+	sum := sha256.Sum256(b)
+	return path, string(sum[:])
+}
+
+// encodeMem gzip-compresses every mapped region's contents alongside its
+// address, size and permissions.
+func (u *Usercorn) encodeMem() ([]byte, error) {
+	regions, err := u.MemRegions()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint64(len(regions)))
+	for _, reg := range regions {
+		data, err := u.MemRead(reg.Begin, reg.End-reg.Begin+1)
+		if err != nil {
+			return nil, err
+		}
+		var gz bytes.Buffer
+		zw := gzip.NewWriter(&gz)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		binary.Write(&buf, binary.LittleEndian, reg.Begin)
+		binary.Write(&buf, binary.LittleEndian, reg.End-reg.Begin+1)
+		binary.Write(&buf, binary.LittleEndian, uint32(reg.Prot))
+		binary.Write(&buf, binary.LittleEndian, uint64(gz.Len()))
+		buf.Write(gz.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+func (u *Usercorn) decodeMem(data []byte) error {
+	r := bytes.NewReader(data)
+	var count uint64
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return err
+	}
+	for i := uint64(0); i < count; i++ {
+		var addr, size uint64
+		var prot uint32
+		var gzLen uint64
+		if err := binary.Read(r, binary.LittleEndian, &addr); err != nil {
+			return err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &prot); err != nil {
+			return err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &gzLen); err != nil {
+			return err
+		}
+		gz := make([]byte, gzLen)
+		if _, err := io.ReadFull(r, gz); err != nil {
+			return err
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(gz))
+		if err != nil {
+			return err
+		}
+		raw, err := ioutil.ReadAll(zr)
+		if err != nil {
+			return err
+		}
+		if err := u.MemMapProt(addr, size, int(prot)); err != nil {
+			return err
+		}
+		if err := u.MemWrite(addr, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *Usercorn) encodeRegs() ([]byte, error) {
+	var buf bytes.Buffer
+	for _, reg := range u.allRegs() {
+		val, err := u.RegRead(reg)
+		if err != nil {
+			return nil, err
+		}
+		binary.Write(&buf, binary.LittleEndian, uint32(reg))
+		binary.Write(&buf, binary.LittleEndian, val)
+	}
+	return buf.Bytes(), nil
+}
+
+// allRegs returns every register index known for u's arch, not just the
+// curated subset GDBRegOrder exposes to the debugger, so a snapshot is a
+// full checkpoint even on archs gdbstub only has pc/sp for.
+func (u *Usercorn) allRegs() []int {
+	seen := make(map[int]bool, len(u.arch.Regs)+2)
+	regs := make([]int, 0, len(u.arch.Regs)+2)
+	add := func(r int) {
+		if !seen[r] {
+			seen[r] = true
+			regs = append(regs, r)
+		}
+	}
+	add(u.arch.PC)
+	add(u.arch.SP)
+	names := make([]string, 0, len(u.arch.Regs))
+	for name := range u.arch.Regs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(u.arch.Regs[name])
+	}
+	return regs
+}
+
+func (u *Usercorn) decodeRegs(data []byte) error {
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		var reg uint32
+		var val uint64
+		if err := binary.Read(r, binary.LittleEndian, &reg); err != nil {
+			return err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &val); err != nil {
+			return err
+		}
+		if err := u.RegWrite(int(reg), val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"debug/gosym"
 	"errors"
 	"fmt"
 	uc "github.com/unicorn-engine/unicorn/bindings/go/unicorn"
@@ -9,11 +10,31 @@ import (
 	"strings"
 
 	"./arch"
+	"./debugger/gdbstub"
 	"./loader"
 	"./models"
 	"./syscalls"
 )
 
+// debugInfoFor builds a models.DebugInfo from l's DWARF data, if any. Loaders
+// that don't carry debug info (or weren't built with -g) simply don't
+// implement models.DwarfLoader, so this is a no-op for them.
+func debugInfoFor(l models.Loader) *models.DebugInfo {
+	dl, ok := l.(models.DwarfLoader)
+	if !ok {
+		return nil
+	}
+	data, err := dl.DWARF()
+	if err != nil || data == nil {
+		return nil
+	}
+	di, err := models.NewDebugInfo(data)
+	if err != nil {
+		return nil
+	}
+	return di
+}
+
 type Usercorn struct {
 	*Unicorn
 	loader       models.Loader
@@ -32,8 +53,26 @@ type Usercorn struct {
 	TraceExec   bool
 	TraceReg    bool
 	LoadPrefix  string
-	status      models.StatusDiff
-	stacktrace  models.Stacktrace
+	GdbAddr     string
+
+	CheckpointEvery int
+	CheckpointFile  string
+	RestoreFile     string
+	blocksSeen      int
+	status          models.StatusDiff
+	stacktrace      models.Stacktrace
+
+	debugInfo       *models.DebugInfo
+	interpDebugInfo *models.DebugInfo
+	goTable         *gosym.Table
+	goInline        *models.GoInlineTree
+	goLoadBias      uint64
+
+	// Step's HOOK_CODE callback, installed once and reused across calls --
+	// see Step for why.
+	stepHookReady    bool
+	stepSkippedFirst bool
+	stepStopped      bool
 
 	// deadlock detection
 	lastBlock uint64
@@ -70,6 +109,9 @@ func NewUsercorn(exe string, prefix string) (*Usercorn, error) {
 }
 
 func (u *Usercorn) Run(args []string, env []string) error {
+	if u.RestoreFile != "" {
+		return u.runFromRestore()
+	}
 	if err := u.addHooks(); err != nil {
 		return err
 	}
@@ -112,7 +154,17 @@ func (u *Usercorn) Run(args []string, env []string) error {
 		sp, _ := u.RegRead(u.arch.SP)
 		u.stacktrace.Update(u.entry, sp)
 	}
-	err := u.Unicorn.Start(u.entry, 0xffffffffffffffff)
+	var err error
+	if u.GdbAddr != "" {
+		stub := gdbstub.New(u)
+		fmt.Fprintf(os.Stderr, "[gdb] waiting for connection on %s]\n", u.GdbAddr)
+		if err = stub.Listen(u.GdbAddr); err != nil {
+			return err
+		}
+		err = stub.Serve(u.entry)
+	} else {
+		err = u.Unicorn.Start(u.entry, 0xffffffffffffffff)
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Registers:")
 		u.status.Changes().Print("", true, false)
@@ -146,6 +198,20 @@ func (u *Usercorn) BinEntry() uint64 {
 	return u.binEntry
 }
 
+// PushStrings writes each of args onto the stack and returns their
+// addresses, for OS personalities (e.g. darwinInit's apple[] vector) that
+// need to lay out their own pointer arrays instead of going through
+// PosixInit.
+func (u *Usercorn) PushStrings(args ...string) ([]uint64, error) {
+	return u.pushStrings(args...)
+}
+
+// PushAddrs pushes addrs as a NULL-terminated pointer array, the same way
+// PosixInit lays out argv/envp.
+func (u *Usercorn) PushAddrs(addrs []uint64) error {
+	return u.pushAddrs(addrs)
+}
+
 func (u *Usercorn) PosixInit(args, env []string, auxv []byte) error {
 	// end marker
 	if err := u.Push(0); err != nil {
@@ -187,7 +253,105 @@ func (u *Usercorn) PrefixPath(path string, force bool) string {
 	return path
 }
 
+// InlinedFrames returns the chain of inlined calls (innermost first)
+// covering the biased addr, preferring DWARF and falling back to the Go
+// pclntab inline tree, in the same source order Symbolicate uses. It's a
+// building block for a stack renderer that wants to splice these in as
+// their own virtual frames rather than the single-line suffix Symbolicate
+// produces below -- models.Stacktrace, the renderer itself, isn't part of
+// this tree, so that splicing can't be wired up here.
+func (u *Usercorn) InlinedFrames(addr uint64) []models.InlinedFrame {
+	if u.debugInfo != nil {
+		if _, _, _, _, inlined := u.debugInfo.LookupPC(addr - u.base); len(inlined) > 0 {
+			return inlined
+		}
+	}
+	if u.interpDebugInfo != nil {
+		if _, _, _, _, inlined := u.interpDebugInfo.LookupPC(addr - u.interpBase); len(inlined) > 0 {
+			return inlined
+		}
+	}
+	if u.goInline != nil {
+		return u.goInline.PCInline(addr, u.goLoadBias)
+	}
+	return nil
+}
+
+// symbolicateDwarf resolves an unbiased addr against di, formatting it as
+// "func at file:line +0xoff", plus an inlined-frame chain when
+// DW_TAG_inlined_subroutine entries cover addr.
+func symbolicateDwarf(di *models.DebugInfo, addr uint64) string {
+	fn, file, line, off, inlined := di.LookupPC(addr)
+	if fn == "" {
+		return ""
+	}
+	s := fmt.Sprintf("%s at %s:%d +0x%x", fn, file, line, off)
+	for _, f := range inlined {
+		s += fmt.Sprintf(" [inlined %s at %s:%d]", f.Func, f.File, f.Line)
+	}
+	return s
+}
+
+// sourceLine looks up the source file:line for a biased addr, consulting
+// the binary's DebugInfo first and falling back to the interpreter's.
+func (u *Usercorn) sourceLine(addr uint64) (file string, line int, ok bool) {
+	if u.debugInfo != nil {
+		if _, f, l, _, _ := u.debugInfo.LookupPC(addr - u.base); f != "" {
+			return f, l, true
+		}
+	}
+	if u.interpDebugInfo != nil {
+		if _, f, l, _, _ := u.interpDebugInfo.LookupPC(addr - u.interpBase); f != "" {
+			return f, l, true
+		}
+	}
+	return "", 0, false
+}
+
+// SymbolAddr resolves a symbol name to its biased load address, the
+// reverse of Symbolicate, consulting the same DWARF/loader symbol sources
+// in the same preference order.
+func (u *Usercorn) SymbolAddr(name string) (uint64, bool) {
+	if u.debugInfo != nil {
+		if addr, ok := u.debugInfo.FuncAddr(name); ok {
+			return addr + u.base, true
+		}
+	}
+	if u.interpDebugInfo != nil {
+		if addr, ok := u.interpDebugInfo.FuncAddr(name); ok {
+			return addr + u.interpBase, true
+		}
+	}
+	if symbols, err := u.loader.Symbols(); err == nil {
+		for _, sym := range symbols {
+			if sym.Name == name {
+				return sym.Start + u.base, true
+			}
+		}
+	}
+	if u.interpLoader != nil {
+		if symbols, err := u.interpLoader.Symbols(); err == nil {
+			for _, sym := range symbols {
+				if sym.Name == name {
+					return sym.Start + u.interpBase, true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
 func (u *Usercorn) Symbolicate(addr uint64) (string, error) {
+	if u.debugInfo != nil {
+		if s := symbolicateDwarf(u.debugInfo, addr-u.base); s != "" {
+			return s, nil
+		}
+	}
+	if u.interpDebugInfo != nil {
+		if s := symbolicateDwarf(u.interpDebugInfo, addr-u.interpBase); s != "" {
+			return s, nil
+		}
+	}
 	var symbolicate = func(addr uint64, symbols []models.Symbol) (result models.Symbol, distance uint64) {
 		if len(symbols) == 0 {
 			return
@@ -220,12 +384,110 @@ func (u *Usercorn) Symbolicate(addr uint64) (string, error) {
 		sym = isym
 		sdist = idist
 	}
+	if u.goTable != nil && (sym.Name == "" || models.IsGenericGoSymbol(sym.Name)) {
+		if s := symbolicateGo(u.goTable, addr); s != "" {
+			if u.goInline != nil {
+				for _, f := range u.goInline.PCInline(addr, u.goLoadBias) {
+					s += fmt.Sprintf(" [inlined %s]", f.Func)
+				}
+			}
+			return s, nil
+		}
+	}
 	if sym.Name != "" {
 		return fmt.Sprintf("%s+0x%x", sym.Name, sdist), nil
 	}
 	return "", nil
 }
 
+// symbolicateGo resolves a biased addr against the Go binary's pclntab,
+// returning "pkg.Func at file:line" for the outermost frame. debug/gosym
+// doesn't expose the funcdata inline tree itself, so any inlined frames
+// covering addr are appended separately by Symbolicate via u.goInline.
+func symbolicateGo(tab *gosym.Table, addr uint64) string {
+	file, line, fn := tab.PCToLine(addr)
+	if fn == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s at %s:%d", fn.Name, file, line)
+}
+
+// GoRoutineDump prints the current goroutine's Go-level call stack,
+// resolved through the pclntab table built in mapBinary, as a companion to
+// the raw stacktrace the block hook already produces. It finds the running
+// g by reading the TLS-resident g pointer (fs:-8 on amd64, r28 on arm64)
+// and walks g.sched down to the frame pointer chain.
+func (u *Usercorn) GoRoutineDump() error {
+	if u.goTable == nil {
+		return errors.New("GoRoutineDump: not a recognized Go binary")
+	}
+	g, err := u.currentG()
+	if err != nil {
+		return err
+	}
+	// g.sched (a gobuf) sits at offset 56 in g, per the runtime2.go g/gobuf
+	// layout used by modern (1.4+) amd64/arm64 runtimes. gobuf itself is
+	// {sp, pc, g, ctxt, ret, lr, bp}, so pc is 8 bytes into it and bp is
+	// 48 bytes in, not immediately after pc.
+	const (
+		schedOffset   = 56
+		gobufPCOffset = 8
+		gobufBPOffset = 48
+	)
+	buf := make([]byte, 8)
+	if err := u.MemReadInto(buf, g+schedOffset+gobufPCOffset); err != nil {
+		return err
+	}
+	pc := u.ByteOrder.Uint64(buf)
+	if err := u.MemReadInto(buf, g+schedOffset+gobufBPOffset); err != nil {
+		return err
+	}
+	bp := u.ByteOrder.Uint64(buf)
+	fmt.Fprintln(os.Stderr, "Go stack:")
+	for depth := 0; pc != 0 && depth < 64; depth++ {
+		file, line, fn := u.goTable.PCToLine(pc)
+		if fn == nil {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "  %s\n      %s:%d\n", fn.Name, file, line)
+		// Walk the BP chain: saved BP is at [bp], return PC at [bp+8].
+		if bp == 0 {
+			break
+		}
+		if err := u.MemReadInto(buf, bp+8); err != nil {
+			break
+		}
+		pc = u.ByteOrder.Uint64(buf)
+		if err := u.MemReadInto(buf, bp); err != nil {
+			break
+		}
+		bp = u.ByteOrder.Uint64(buf)
+	}
+	return nil
+}
+
+// currentG locates the running goroutine's g struct via the TLS slot the
+// Go runtime keeps it in.
+func (u *Usercorn) currentG() (uint64, error) {
+	switch u.arch.Bits {
+	case 64:
+		if u.arch.Name == "arm64" {
+			return u.RegRead(u.arch.Regs["R28"])
+		}
+		// amd64: TLS base is FS; the g pointer lives at fs:-8.
+		fs, err := u.RegRead(u.arch.Regs["FS_BASE"])
+		if err != nil {
+			return 0, err
+		}
+		buf := make([]byte, 8)
+		if err := u.MemReadInto(buf, fs-8); err != nil {
+			return 0, err
+		}
+		return u.ByteOrder.Uint64(buf), nil
+	}
+	return 0, errors.New("currentG: unsupported arch")
+}
+
 func (u *Usercorn) Brk(addr uint64) (uint64, error) {
 	// TODO: this is linux specific
 	s := u.DataSegment
@@ -236,6 +498,93 @@ func (u *Usercorn) Brk(addr uint64) (uint64, error) {
 	return s.End, nil
 }
 
+// HookAddAddr installs a HOOK_CODE callback that only fires for the single
+// address addr, for gdbstub's software breakpoints. Unicorn has no call to
+// unregister a hook mid-run, so the returned disable func flips a flag the
+// callback checks instead; that's how gdbstub's z0 packets take effect.
+func (u *Usercorn) HookAddAddr(addr uint64, cb func()) (func(), error) {
+	enabled := true
+	err := u.HookAdd(uc.HOOK_CODE, func(_ uc.Unicorn, a uint64, size uint32) {
+		if enabled && a == addr {
+			cb()
+		}
+	})
+	return func() { enabled = false }, err
+}
+
+// HookAddRange installs a HOOK_CODE callback that fires for any address in
+// [begin, end), for gdbstub's hardware breakpoints. See HookAddAddr for why
+// it returns a disable func rather than truly removing the hook.
+func (u *Usercorn) HookAddRange(begin, end uint64, cb func()) (func(), error) {
+	enabled := true
+	err := u.HookAdd(uc.HOOK_CODE, func(_ uc.Unicorn, a uint64, size uint32) {
+		if enabled && a >= begin && a < end {
+			cb()
+		}
+	})
+	return func() { enabled = false }, err
+}
+
+// PC returns the arch's current program counter, for gdbstub to report
+// where execution stopped after a continue or step.
+func (u *Usercorn) PC() (uint64, error) {
+	return u.RegRead(u.arch.PC)
+}
+
+// Step executes exactly one instruction starting at pc and returns the PC
+// it stopped at, for gdbstub's 's' packet. Unicorn's HOOK_CODE fires before an
+// instruction executes, so the hook ignores that first callback (pc
+// itself) and stops on the second. The hook itself is installed once and
+// reused across calls (state reset per call) rather than added fresh each
+// time -- this codebase's HookAdd has no way to remove a hook, so a new one
+// per Step would accumulate one permanently-live callback per single-step,
+// slowing (and eventually stalling) a long GDB stepping session.
+func (u *Usercorn) Step(pc uint64) (uint64, error) {
+	u.stepSkippedFirst = false
+	u.stepStopped = false
+	if !u.stepHookReady {
+		err := u.HookAdd(uc.HOOK_CODE, func(_ uc.Unicorn, a uint64, size uint32) {
+			if u.stepStopped {
+				return
+			}
+			if !u.stepSkippedFirst {
+				u.stepSkippedFirst = true
+				return
+			}
+			u.stepStopped = true
+			u.Stop()
+		})
+		if err != nil {
+			return pc, err
+		}
+		u.stepHookReady = true
+	}
+	runErr := u.Unicorn.Start(pc, 0xffffffffffffffff)
+	stopPC, regErr := u.PC()
+	if regErr != nil {
+		return pc, runErr
+	}
+	return stopPC, runErr
+}
+
+// RegOrder returns the arch's register file in the order gdbstub's g/G
+// packets expect, i.e. the same order as TargetXML's target.xml.
+func (u *Usercorn) RegOrder() []int {
+	return models.GDBRegOrder(u.arch)
+}
+
+// RegSizes returns the byte width of each register RegOrder returns, in
+// the same order, matching the bitsize TargetXML advertises for it.
+func (u *Usercorn) RegSizes() []int {
+	return models.GDBRegSizes(u.arch)
+}
+
+// TargetXML returns a GDB target description for the arch, so `qXfer:
+// features:read:target.xml` can tell GDB which registers g/G will send.
+func (u *Usercorn) TargetXML() string {
+	return models.GDBTargetXML(u.arch)
+}
+
 func (u *Usercorn) addHooks() error {
 	if u.TraceExec || u.TraceReg {
 		u.HookAdd(uc.HOOK_BLOCK, func(_ uc.Unicorn, addr uint64, size uint32) {
@@ -248,6 +597,9 @@ func (u *Usercorn) addHooks() error {
 			if sym != "" {
 				sym = " (" + sym + ")"
 			}
+			if file, line, ok := u.sourceLine(addr); ok {
+				fmt.Fprintf(os.Stderr, "\n"+blockIndent+"  %s:%d", file, line)
+			}
 			blockLine := fmt.Sprintf("\n"+blockIndent+"+ block%s @0x%x", sym, addr)
 			if !u.TraceExec && u.TraceReg && u.deadlock == 0 {
 				changes := u.status.Changes()
@@ -269,6 +621,9 @@ func (u *Usercorn) addHooks() error {
 				changes = u.status.Changes()
 			}
 			if u.TraceExec {
+				if file, line, ok := u.sourceLine(addr); ok {
+					fmt.Fprintf(os.Stderr, "%s; %s:%d\n", indent, file, line)
+				}
 				dis, _ := u.Disas(addr, uint64(size))
 				fmt.Fprintf(os.Stderr, "%s", indent+dis)
 				if !u.TraceReg || changes.Count() == 0 {
@@ -306,6 +661,16 @@ func (u *Usercorn) addHooks() error {
 			u.lastCode = addr
 		})
 	}
+	if u.CheckpointEvery > 0 {
+		u.HookAdd(uc.HOOK_BLOCK, func(_ uc.Unicorn, addr uint64, size uint32) {
+			u.blocksSeen++
+			if u.blocksSeen%u.CheckpointEvery == 0 {
+				if err := u.writeCheckpoint(); err != nil {
+					fmt.Fprintf(os.Stderr, "checkpoint failed: %s\n", err)
+				}
+			}
+		})
+	}
 	if u.TraceMem {
 		hexFmt := fmt.Sprintf("0x%%0%dx", u.Bsz*2)
 		memFmt := fmt.Sprintf("%%s %s %%d %s\n", hexFmt, hexFmt)
@@ -390,6 +755,25 @@ outer:
 		}
 	}
 	entry = loadBias + l.Entry()
+	if l == u.loader {
+		u.debugInfo = debugInfoFor(l)
+		if sl, ok := l.(models.SectionLoader); ok {
+			if tab, err := models.NewGoTable(sl, loadBias); err == nil {
+				u.goTable = tab
+			}
+			// Inline frames for stripped Go binaries: only available if l
+			// also exposes the raw "go:func.*" symbol that FUNCDATA_InlTree
+			// is addressed against, which most loaders don't implement yet.
+			if sdl, ok := l.(models.SymbolDataLoader); ok {
+				if _, pclntab, err := sl.Section(".gopclntab"); err == nil && len(pclntab) > 0 {
+					if gofunc, err := sdl.SymbolData("go:func.*"); err == nil {
+						u.goInline = models.NewGoInlineTree(pclntab, gofunc)
+						u.goLoadBias = loadBias
+					}
+				}
+			}
+		}
+	}
 	// load interpreter if present
 	interp := l.Interp()
 	if interp != "" {
@@ -399,6 +783,7 @@ outer:
 			return
 		}
 		u.interpLoader = bin
+		u.interpDebugInfo = debugInfoFor(bin)
 		_, _, interpBias, interpEntry, err := u.mapBinary(bin)
 		return interpBias, interpEntry, loadBias, entry, err
 	} else {